@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// authClient performs Docker-distribution bearer-token authentication
+// against a registry, caching the token it obtains so that manifest and blob
+// requests against the same realm/service/scope don't each trigger a fresh
+// token exchange.
+//
+// See https://distribution.github.io/distribution/spec/auth/token/ for the
+// flow this implements.
+type authClient struct {
+	username string
+	password string
+	token    string // Set via -token; bypasses username/password and the token endpoint entirely.
+
+	cachedToken string
+}
+
+// newAuthClient builds an authClient from the -username/-password/-token
+// flags, falling back to ~/.docker/config.json (or $DOCKER_CONFIG) for the
+// given registry if none of those are set.
+func newAuthClient(registry, username, password, token string) *authClient {
+	if token == "" && username == "" && password == "" {
+		if u, p, ok := dockerConfigCredentials(registry); ok {
+			username, password = u, p
+		}
+	}
+	return &authClient{username: username, password: password, token: token}
+}
+
+// do performs req, transparently handling a 401 Unauthorized by obtaining a
+// bearer token from the challenge's realm and retrying once with an
+// Authorization header attached. The token is cached on the client for reuse
+// across subsequent calls to do.
+func (c *authClient) do(req *http.Request) (*http.Response, error) {
+	if c.cachedToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cachedToken)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if challenge == "" {
+		return nil, fmt.Errorf("received 401 with no WWW-Authenticate challenge")
+	}
+
+	tok, err := c.requestToken(challenge)
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate: %w", err)
+	}
+	c.cachedToken = tok
+
+	retry := req.Clone(req.Context())
+	retry.Header.Set("Authorization", "Bearer "+c.cachedToken)
+	return http.DefaultClient.Do(retry)
+}
+
+// requestToken performs the token request described by a "Bearer ..."
+// WWW-Authenticate challenge and returns the bearer token to use.
+func (c *authClient) requestToken(challenge string) (string, error) {
+	if c.token != "" {
+		return c.token, nil
+	}
+	params, err := parseBearerChallenge(challenge)
+	if err != nil {
+		return "", err
+	}
+	realm, ok := params["realm"]
+	if !ok {
+		return "", fmt.Errorf("challenge missing realm: %q", challenge)
+	}
+
+	q := url.Values{}
+	if service, ok := params["service"]; ok {
+		q.Set("service", service)
+	}
+	if scope, ok := params["scope"]; ok {
+		q.Set("scope", scope)
+	}
+
+	tokenURL := realm
+	if encoded := q.Encode(); encoded != "" {
+		tokenURL += "?" + encoded
+	}
+	req, err := http.NewRequest(http.MethodGet, tokenURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build token request: %w", err)
+	}
+	if c.username != "" || c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request to %s failed: unexpected status %s", realm, resp.Status)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", fmt.Errorf("token response from %s contained neither token nor access_token", realm)
+}
+
+// resolveBlobLocation issues a HEAD request for blobURL and reports where
+// the blob actually lives once registry redirects are followed, and the
+// bearer token to present there, if any. Many registries (Ollama's
+// included) redirect blob requests to a presigned URL on S3 or similar,
+// which must be fetched without the Authorization header; others serve the
+// blob directly and require the token on every request. Go's http.Client
+// already strips Authorization across a cross-host redirect, so the token
+// is only needed again when the final host matches the one we requested.
+func (c *authClient) resolveBlobLocation(blobURL string) (finalURL, token string, err error) {
+	req, err := http.NewRequest(http.MethodHead, blobURL, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to build blob request: %w", err)
+	}
+	requestedHost := req.URL.Host
+
+	resp, err := c.do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve blob location: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("failed to resolve blob location: unexpected status %s", resp.Status)
+	}
+
+	finalURL = resp.Request.URL.String()
+	if resp.Request.URL.Host == requestedHost {
+		token = c.cachedToken
+	}
+	return finalURL, token, nil
+}
+
+// parseBearerChallenge parses a WWW-Authenticate header of the form
+// `Bearer realm="...",service="...",scope="..."` into its key/value pairs.
+func parseBearerChallenge(challenge string) (map[string]string, error) {
+	scheme, rest, ok := strings.Cut(challenge, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, fmt.Errorf("unsupported auth challenge: %q", challenge)
+	}
+
+	params := map[string]string{}
+	for _, part := range splitChallengeParams(rest) {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		params[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return params, nil
+}
+
+// splitChallengeParams splits a comma-separated list of key="value" pairs,
+// respecting commas embedded inside quoted values (e.g. a scope listing
+// multiple repositories).
+func splitChallengeParams(s string) []string {
+	var parts []string
+	var inQuotes bool
+	start := 0
+	for i, r := range s {
+		switch r {
+		case '"':
+			inQuotes = !inQuotes
+		case ',':
+			if !inQuotes {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, s[start:])
+	return parts
+}
+
+// dockerConfigCredentials looks up a username/password for registry in the
+// Docker config file pointed at by $DOCKER_CONFIG (or ~/.docker/config.json
+// otherwise), returning ok=false if no config or no matching entry exists.
+func dockerConfigCredentials(registry string) (username, password string, ok bool) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", false
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return "", "", false
+	}
+
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", "", false
+	}
+	entry, found := config.Auths[registry]
+	if !found {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+	if err != nil {
+		return "", "", false
+	}
+	username, password, ok = strings.Cut(string(decoded), ":")
+	return username, password, ok
+}
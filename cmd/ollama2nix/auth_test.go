@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBearerChallenge(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge string
+		want      map[string]string
+		wantErr   bool
+	}{
+		{
+			name:      "realm service and scope",
+			challenge: `Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/mistral-nemo:pull"`,
+			want: map[string]string{
+				"realm":   "https://auth.docker.io/token",
+				"service": "registry.docker.io",
+				"scope":   "repository:library/mistral-nemo:pull",
+			},
+		},
+		{
+			name:      "scheme is matched case-insensitively",
+			challenge: `bearer realm="https://auth.example.com/token"`,
+			want:      map[string]string{"realm": "https://auth.example.com/token"},
+		},
+		{
+			name:      "scope listing multiple repositories embeds a comma",
+			challenge: `Bearer realm="https://auth.example.com/token",scope="repository:a:pull,repository:b:pull"`,
+			want: map[string]string{
+				"realm": "https://auth.example.com/token",
+				"scope": "repository:a:pull,repository:b:pull",
+			},
+		},
+		{
+			name:      "non-bearer scheme is an error",
+			challenge: `Basic realm="registry"`,
+			wantErr:   true,
+		},
+		{
+			name:      "missing scheme separator is an error",
+			challenge: `Bearer`,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBearerChallenge(tt.challenge)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBearerChallenge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseBearerChallenge() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitChallengeParams(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want []string
+	}{
+		{
+			name: "simple comma-separated pairs",
+			s:    `realm="https://auth.example.com/token",service="registry.example.com"`,
+			want: []string{`realm="https://auth.example.com/token"`, `service="registry.example.com"`},
+		},
+		{
+			name: "comma embedded in a quoted value is not a split point",
+			s:    `scope="repository:a:pull,repository:b:pull"`,
+			want: []string{`scope="repository:a:pull,repository:b:pull"`},
+		},
+		{
+			name: "single pair with no commas",
+			s:    `realm="https://auth.example.com/token"`,
+			want: []string{`realm="https://auth.example.com/token"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitChallengeParams(tt.s)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitChallengeParams() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Ollama media types for the layers a Modelfile build can produce.
+const (
+	mediaTypeOllamaModel    = "application/vnd.ollama.image.model"
+	mediaTypeOllamaTemplate = "application/vnd.ollama.image.template"
+	mediaTypeOllamaLicense  = "application/vnd.ollama.image.license"
+	mediaTypeOllamaParams   = "application/vnd.ollama.image.params"
+	mediaTypeOllamaConfig   = "application/vnd.docker.container.image.v1+json"
+)
+
+// modelfile is the parsed form of a Modelfile-like build input, mirroring
+// the directives Ollama's own Modelfile format supports: FROM, TEMPLATE,
+// PARAMETER and LICENSE.
+type modelfile struct {
+	From       string
+	Template   string
+	License    string
+	Parameters map[string][]string
+}
+
+// runBuild implements the "build" subcommand: turn a Modelfile plus a local
+// GGUF file into a manifest + blob store laid out identically to what the
+// Nix derivation from run() produces, so it can be served directly by
+// `ollama serve`, the embedded registry from the "serve" subcommand, or
+// wrapped in a Nix derivation.
+func runBuild(args []string) error {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	modelfilePath := fs.String("modelfile", "Modelfile", "Path to the Modelfile to build.")
+	out := fs.String("out", "build", "Output directory to write the blob store and manifest into.")
+	registry := fs.String("registry", "registry.ollama.ai", "Registry name to file the manifest under, e.g. registry.ollama.ai.")
+	repo := fs.String("repo", "", "Repository path to file the manifest under, e.g. library/mymodel. Required.")
+	tag := fs.String("tag", "latest", "Tag to file the manifest under.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	log := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	if *repo == "" {
+		return fmt.Errorf("repo is required")
+	}
+
+	mf, err := parseModelfile(*modelfilePath)
+	if err != nil {
+		return fmt.Errorf("failed to parse Modelfile: %w", err)
+	}
+	if mf.From == "" {
+		return fmt.Errorf("modelfile has no FROM directive")
+	}
+
+	blobsDir := filepath.Join(*out, "blobs")
+	if err := os.MkdirAll(blobsDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create blobs directory: %w", err)
+	}
+
+	log.Debug("Hashing model weights", slog.String("path", mf.From))
+	modelLayer, err := writeFileBlob(blobsDir, mf.From, mediaTypeOllamaModel)
+	if err != nil {
+		return fmt.Errorf("failed to write model blob: %w", err)
+	}
+	layers := []Layer{modelLayer}
+
+	if mf.Template != "" {
+		templateLayer, err := writeContentBlob(blobsDir, []byte(mf.Template), mediaTypeOllamaTemplate)
+		if err != nil {
+			return fmt.Errorf("failed to write template blob: %w", err)
+		}
+		layers = append(layers, templateLayer)
+	}
+
+	if mf.License != "" {
+		licenseLayer, err := writeContentBlob(blobsDir, []byte(mf.License), mediaTypeOllamaLicense)
+		if err != nil {
+			return fmt.Errorf("failed to write license blob: %w", err)
+		}
+		layers = append(layers, licenseLayer)
+	}
+
+	if len(mf.Parameters) > 0 {
+		paramsData, err := canonicalJSON(flattenParameters(mf.Parameters))
+		if err != nil {
+			return fmt.Errorf("failed to encode parameters: %w", err)
+		}
+		paramsLayer, err := writeContentBlob(blobsDir, paramsData, mediaTypeOllamaParams)
+		if err != nil {
+			return fmt.Errorf("failed to write params blob: %w", err)
+		}
+		layers = append(layers, paramsLayer)
+	}
+
+	// A minimal, deterministic config blob: just enough for registries that
+	// require one, with no machine- or time-specific fields so identical
+	// Modelfiles always produce the same config digest.
+	configData, err := canonicalJSON(map[string]string{"model_format": "gguf"})
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	configLayer, err := writeContentBlob(blobsDir, configData, mediaTypeOllamaConfig)
+	if err != nil {
+		return fmt.Errorf("failed to write config blob: %w", err)
+	}
+
+	manifest := ImageManifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeDockerManifest,
+		Config:        configLayer,
+		Layers:        layers,
+	}
+	manifestData, err := canonicalJSON(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	manifestDir := filepath.Join(*out, "manifests", *registry, *repo)
+	if err := os.MkdirAll(manifestDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create manifest directory: %w", err)
+	}
+	manifestPath := filepath.Join(manifestDir, *tag)
+	if err := os.WriteFile(manifestPath, manifestData, 0o644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	log.Info("Built model", slog.String("manifest", manifestPath), slog.String("digest", manifestDigest(manifestData)))
+	return nil
+}
+
+// writeFileBlob hashes the file at path and copies it into blobsDir as
+// sha256-<hex>, reading it exactly once.
+func writeFileBlob(blobsDir, path, mediaType string) (Layer, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return Layer{}, err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return Layer{}, err
+	}
+
+	hasher := sha256.New()
+	tmp, err := os.CreateTemp(blobsDir, ".blob-*")
+	if err != nil {
+		return Layer{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, io.TeeReader(src, hasher)); err != nil {
+		return Layer{}, err
+	}
+	digest := fmt.Sprintf("sha256:%x", hasher.Sum(nil))
+
+	dest := filepath.Join(blobsDir, strings.Replace(digest, ":", "-", 1))
+	if err := tmp.Close(); err != nil {
+		return Layer{}, err
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return Layer{}, err
+	}
+	return Layer{Digest: digest, MediaType: mediaType, Size: info.Size()}, nil
+}
+
+// writeContentBlob hashes data and writes it into blobsDir as sha256-<hex>,
+// via a temp file and rename so a killed build can never leave a
+// digest-named file with content that doesn't match its name.
+func writeContentBlob(blobsDir string, data []byte, mediaType string) (Layer, error) {
+	digest := manifestDigest(data)
+
+	tmp, err := os.CreateTemp(blobsDir, ".blob-*")
+	if err != nil {
+		return Layer{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return Layer{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return Layer{}, err
+	}
+
+	dest := filepath.Join(blobsDir, strings.Replace(digest, ":", "-", 1))
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return Layer{}, err
+	}
+	return Layer{Digest: digest, MediaType: mediaType, Size: int64(len(data))}, nil
+}
+
+// flattenParameters collapses single-valued PARAMETER entries to a bare
+// value and leaves repeated ones (e.g. multiple "stop" sequences) as an
+// array, matching how Ollama itself renders params.json.
+func flattenParameters(params map[string][]string) map[string]any {
+	flat := make(map[string]any, len(params))
+	for key, values := range params {
+		if len(values) == 1 {
+			flat[key] = values[0]
+			continue
+		}
+		flat[key] = values
+	}
+	return flat
+}
+
+// canonicalJSON marshals v with its map keys sorted (encoding/json already
+// does this) and no extra whitespace, so repeated builds of the same input
+// produce byte-identical output.
+func canonicalJSON(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// parseModelfile reads a Modelfile-like file: one directive per line, plus
+// optional triple-quoted (`"""`) multi-line blocks for directives whose
+// value spans more than one line, e.g.:
+//
+//	FROM ./mymodel.gguf
+//	TEMPLATE """{{ .Prompt }}"""
+//	PARAMETER temperature 0.7
+//	LICENSE """
+//	... full license text ...
+//	"""
+func parseModelfile(path string) (*modelfile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mf := &modelfile{Parameters: map[string][]string{}}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		directive, rest, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		directive = strings.ToUpper(directive)
+		rest = strings.TrimSpace(rest)
+
+		value, err := readDirectiveValue(scanner, rest)
+		if err != nil {
+			return nil, err
+		}
+
+		switch directive {
+		case "FROM":
+			mf.From = value
+			if !filepath.IsAbs(mf.From) {
+				mf.From = filepath.Join(filepath.Dir(path), mf.From)
+			}
+		case "TEMPLATE":
+			mf.Template = value
+		case "LICENSE":
+			mf.License = value
+		case "PARAMETER":
+			key, paramValue, ok := strings.Cut(value, " ")
+			if !ok {
+				return nil, fmt.Errorf("malformed PARAMETER directive: %q", value)
+			}
+			mf.Parameters[key] = append(mf.Parameters[key], strings.TrimSpace(paramValue))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mf, nil
+}
+
+// readDirectiveValue returns a directive's value, reading subsequent lines
+// from scanner if rest opens a `"""` block that isn't closed on the same
+// line.
+func readDirectiveValue(scanner *bufio.Scanner, rest string) (string, error) {
+	if !strings.HasPrefix(rest, `"""`) {
+		return rest, nil
+	}
+	rest = strings.TrimPrefix(rest, `"""`)
+	if closed, ok := strings.CutSuffix(rest, `"""`); ok {
+		return closed, nil
+	}
+
+	var b strings.Builder
+	b.WriteString(rest)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if closed, ok := strings.CutSuffix(line, `"""`); ok {
+			b.WriteString("\n")
+			b.WriteString(closed)
+			return b.String(), nil
+		}
+		b.WriteString("\n")
+		b.WriteString(line)
+	}
+	return "", fmt.Errorf(`unterminated """ block`)
+}
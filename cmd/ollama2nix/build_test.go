@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseModelfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     *modelfile
+		wantErr  bool
+	}{
+		{
+			name: "simple directives",
+			contents: strings.Join([]string{
+				`FROM ./mymodel.gguf`,
+				`TEMPLATE """{{ .Prompt }}"""`,
+				`PARAMETER temperature 0.7`,
+			}, "\n"),
+			want: &modelfile{
+				From:       "mymodel.gguf",
+				Template:   "{{ .Prompt }}",
+				Parameters: map[string][]string{"temperature": {"0.7"}},
+			},
+		},
+		{
+			name: "repeated PARAMETER keys accumulate",
+			contents: strings.Join([]string{
+				`FROM ./mymodel.gguf`,
+				`PARAMETER stop "<|user|>"`,
+				`PARAMETER stop "<|assistant|>"`,
+			}, "\n"),
+			want: &modelfile{
+				From:       "mymodel.gguf",
+				Parameters: map[string][]string{"stop": {`"<|user|>"`, `"<|assistant|>"`}},
+			},
+		},
+		{
+			name: "FROM with absolute path is left unchanged",
+			contents: strings.Join([]string{
+				`FROM /opt/models/mymodel.gguf`,
+			}, "\n"),
+			want: &modelfile{
+				From:       "/opt/models/mymodel.gguf",
+				Parameters: map[string][]string{},
+			},
+		},
+		{
+			name: "multi-line LICENSE block",
+			contents: strings.Join([]string{
+				`FROM ./mymodel.gguf`,
+				`LICENSE """`,
+				`line one`,
+				`line two`,
+				`"""`,
+			}, "\n"),
+			want: &modelfile{
+				From:       "mymodel.gguf",
+				License:    "\nline one\nline two\n",
+				Parameters: map[string][]string{},
+			},
+		},
+		{
+			name: "comments and blank lines are ignored",
+			contents: strings.Join([]string{
+				`# a comment`,
+				``,
+				`FROM ./mymodel.gguf`,
+			}, "\n"),
+			want: &modelfile{
+				From:       "mymodel.gguf",
+				Parameters: map[string][]string{},
+			},
+		},
+		{
+			name: "malformed PARAMETER directive",
+			contents: strings.Join([]string{
+				`FROM ./mymodel.gguf`,
+				`PARAMETER temperature`,
+			}, "\n"),
+			wantErr: true,
+		},
+		{
+			name: "unterminated triple-quoted block",
+			contents: strings.Join([]string{
+				`FROM ./mymodel.gguf`,
+				`LICENSE """`,
+				`unterminated`,
+			}, "\n"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "models", "Modelfile")
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(tt.contents), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := parseModelfile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseModelfile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			// FROM is resolved relative to the Modelfile's directory, which
+			// lives under a fresh t.TempDir() per case, so relative wants are
+			// rebased onto it before comparing.
+			want := *tt.want
+			if !filepath.IsAbs(want.From) {
+				want.From = filepath.Join(filepath.Dir(path), want.From)
+			}
+			if !reflect.DeepEqual(got, &want) {
+				t.Errorf("parseModelfile() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadDirectiveValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		rest    string
+		lines   []string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "plain value has no block to read",
+			rest: "0.7",
+			want: "0.7",
+		},
+		{
+			name: "triple-quoted block closed on the same line",
+			rest: `"""{{ .Prompt }}"""`,
+			want: "{{ .Prompt }}",
+		},
+		{
+			name:  "triple-quoted block spanning multiple lines",
+			rest:  `"""`,
+			lines: []string{"line one", `line two"""`},
+			want:  "\nline one\nline two",
+		},
+		{
+			name:    "unterminated block",
+			rest:    `"""`,
+			lines:   []string{"line one"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scanner := bufio.NewScanner(strings.NewReader(strings.Join(tt.lines, "\n")))
+			got, err := readDirectiveValue(scanner, tt.rest)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readDirectiveValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("readDirectiveValue() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BlobSource reads blobs out of some model store: a registry, a local OCI
+// layout directory, or an Ollama models directory. It has no method for
+// reading manifests.
+type BlobSource interface {
+	// GetBlob opens digest for reading, reporting its size.
+	GetBlob(repo, digest string) (r io.ReadCloser, size int64, err error)
+}
+
+// BlobSink writes blobs and manifests into some model store.
+type BlobSink interface {
+	// HasBlob reports whether digest is already present, so the copy engine
+	// can skip re-uploading it.
+	HasBlob(repo, digest string) (bool, error)
+	// PutBlob writes size bytes read from r as digest. Implementations must
+	// not trust digest without verification; the copy engine itself
+	// verifies the bytes actually hash to digest as they stream through.
+	PutBlob(repo, digest string, size int64, r io.Reader) error
+	// PutManifest writes data as the manifest for repo:ref.
+	PutManifest(repo, ref, mediaType string, data []byte) error
+}
+
+// runCopy implements the "copy" subcommand: move a model from a source
+// registry to another store (registry, local OCI layout, or Ollama models
+// directory) without ever touching Nix, verifying every blob's digest as it
+// streams.
+func runCopy(args []string) error {
+	fs := flag.NewFlagSet("copy", flag.ExitOnError)
+	srcRegistry := fs.String("src-registry", "registry.ollama.ai", "Registry to copy the model from.")
+	model := fs.String("model", "", "Name of the model to copy, e.g. mistral-nemo, or mistral-nemo:7b")
+	variant := fs.String("variant", "", "Quantization variant to select when the source tag is a manifest list.")
+	platform := fs.String("platform", "", "Platform to select when the source tag is a manifest list.")
+	username := fs.String("username", "", "Username for source registry authentication.")
+	password := fs.String("password", "", "Password for source registry authentication.")
+	token := fs.String("token", "", "Bearer token for source registry authentication.")
+
+	dstRegistry := fs.String("dst-registry", "", "Registry to copy the model to. Mutually exclusive with -dst-oci-layout and -dst-ollama-store.")
+	dstUsername := fs.String("dst-username", "", "Username for destination registry authentication.")
+	dstPassword := fs.String("dst-password", "", "Password for destination registry authentication.")
+	dstToken := fs.String("dst-token", "", "Bearer token for destination registry authentication.")
+	dstOCILayout := fs.String("dst-oci-layout", "", "Write to a local OCI image layout directory instead of a registry.")
+	dstOllamaStore := fs.String("dst-ollama-store", "", "Write to a local Ollama models directory (~/.ollama/models layout) instead of a registry.")
+	dstRepo := fs.String("dst-repo", "", "Destination repository path. Defaults to the source repository.")
+	dstRef := fs.String("dst-ref", "", "Destination tag. Defaults to the source tag.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	log := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	if *model == "" {
+		return fmt.Errorf("model is required")
+	}
+
+	modelVersion := strings.SplitN(*model, ":", 2)
+	name := modelVersion[0]
+	version := "latest"
+	if len(modelVersion) > 1 {
+		version = modelVersion[1]
+	}
+	repo := repoForModel(name)
+
+	srcClient := newAuthClient(*srcRegistry, *username, *password, *token)
+	selector := ManifestSelector{Variant: *variant, Platform: *platform}
+	log.Debug("Resolving source manifest", slog.String("registry", *srcRegistry), slog.String("repo", repo), slog.String("ref", version))
+	data, _, manifest, err := fetchManifest(srcClient, *srcRegistry, repo, version, selector)
+	if err != nil {
+		return fmt.Errorf("failed to resolve source manifest: %w", err)
+	}
+
+	source := BlobSource(&registryStore{registry: *srcRegistry, client: srcClient})
+
+	var sink BlobSink
+	switch {
+	case *dstOCILayout != "":
+		sink = &ociLayoutStore{root: *dstOCILayout}
+	case *dstOllamaStore != "":
+		sink = &ollamaStore{root: *dstOllamaStore}
+	case *dstRegistry != "":
+		dstClient := newAuthClient(*dstRegistry, *dstUsername, *dstPassword, *dstToken)
+		sink = &registryStore{registry: *dstRegistry, client: dstClient}
+	default:
+		return fmt.Errorf("one of -dst-registry, -dst-oci-layout or -dst-ollama-store is required")
+	}
+
+	destRepo, destRef := *dstRepo, *dstRef
+	if destRepo == "" {
+		destRepo = repo
+	}
+	if destRef == "" {
+		destRef = version
+	}
+
+	layers := append([]Layer{manifest.Config}, manifest.Layers...)
+	for _, layer := range layers {
+		if err := copyBlob(log, source, sink, repo, destRepo, layer); err != nil {
+			return fmt.Errorf("failed to copy blob %s: %w", layer.Digest, err)
+		}
+	}
+
+	log.Debug("Uploading manifest", slog.String("repo", destRepo), slog.String("ref", destRef))
+	if err := sink.PutManifest(destRepo, destRef, manifest.MediaType, data); err != nil {
+		return fmt.Errorf("failed to upload manifest: %w", err)
+	}
+	return nil
+}
+
+// copyBlob streams one blob from source to sink, skipping it if the
+// destination already has it, and verifying the bytes that were actually
+// transferred hash to the digest the manifest claims.
+func copyBlob(log *slog.Logger, source BlobSource, sink BlobSink, srcRepo, dstRepo string, layer Layer) error {
+	exists, err := sink.HasBlob(dstRepo, layer.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to check destination for existing blob: %w", err)
+	}
+	if exists {
+		log.Debug("Blob already present at destination, skipping", slog.String("digest", layer.Digest))
+		return nil
+	}
+
+	r, _, err := source.GetBlob(srcRepo, layer.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to read blob from source: %w", err)
+	}
+	defer r.Close()
+
+	// layer.Size comes from the manifest, not the transport: an HTTP
+	// response's ContentLength is -1 whenever the body is chunked or
+	// transparently gzip-decoded, which would otherwise corrupt the
+	// Content-Range on a registry upload.
+	hasher := sha256.New()
+	if err := sink.PutBlob(dstRepo, layer.Digest, layer.Size, io.TeeReader(r, hasher)); err != nil {
+		return fmt.Errorf("failed to write blob to destination: %w", err)
+	}
+
+	if computed := fmt.Sprintf("sha256:%x", hasher.Sum(nil)); computed != layer.Digest {
+		return fmt.Errorf("digest mismatch: manifest claims %s, got %s", layer.Digest, computed)
+	}
+	return nil
+}
+
+// registryStore implements BlobSource and BlobSink against a remote
+// distribution-spec registry.
+type registryStore struct {
+	registry string
+	client   *authClient
+}
+
+func (s *registryStore) blobURL(repo, digest string) string {
+	return (&url.URL{
+		Scheme: "https",
+		Host:   s.registry,
+		Path:   fmt.Sprintf("/v2/%s/blobs/%s", repo, digest),
+	}).String()
+}
+
+func (s *registryStore) GetBlob(repo, digest string) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequest(http.MethodGet, s.blobURL(repo, digest), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := s.client.do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("unexpected status fetching blob: %s", resp.Status)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+func (s *registryStore) HasBlob(repo, digest string) (bool, error) {
+	req, err := http.NewRequest(http.MethodHead, s.blobURL(repo, digest), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.client.do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// PutBlob implements the distribution-spec chunked upload protocol: start an
+// upload session, PATCH the content in a single chunk, then finalize with a
+// PUT carrying the expected digest.
+func (s *registryStore) PutBlob(repo, digest string, size int64, r io.Reader) error {
+	startReq, err := http.NewRequest(http.MethodPost, (&url.URL{
+		Scheme: "https",
+		Host:   s.registry,
+		Path:   fmt.Sprintf("/v2/%s/blobs/uploads/", repo),
+	}).String(), nil)
+	if err != nil {
+		return err
+	}
+	startResp, err := s.client.do(startReq)
+	if err != nil {
+		return fmt.Errorf("failed to start upload: %w", err)
+	}
+	startResp.Body.Close()
+	if startResp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("failed to start upload: unexpected status %s", startResp.Status)
+	}
+	// Location() resolves a relative Location header against the request
+	// URL; the distribution spec permits registries to return either.
+	uploadURL, err := startResp.Location()
+	if err != nil {
+		return fmt.Errorf("failed to resolve upload location: %w", err)
+	}
+
+	patchReq, err := http.NewRequest(http.MethodPatch, uploadURL.String(), r)
+	if err != nil {
+		return err
+	}
+	patchReq.Header.Set("Content-Type", "application/octet-stream")
+	patchReq.Header.Set("Content-Range", fmt.Sprintf("0-%d", size-1))
+	patchReq.ContentLength = size
+	patchResp, err := s.client.do(patchReq)
+	if err != nil {
+		return fmt.Errorf("failed to upload blob chunk: %w", err)
+	}
+	patchResp.Body.Close()
+	if patchResp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("failed to upload blob chunk: unexpected status %s", patchResp.Status)
+	}
+
+	finalURL, err := patchResp.Location()
+	if err != nil {
+		return fmt.Errorf("failed to resolve upload location: %w", err)
+	}
+	q := finalURL.Query()
+	q.Set("digest", digest)
+	finalURL.RawQuery = q.Encode()
+	putReq, err := http.NewRequest(http.MethodPut, finalURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	putResp, err := s.client.do(putReq)
+	if err != nil {
+		return fmt.Errorf("failed to finalize upload: %w", err)
+	}
+	defer putResp.Body.Close()
+	if putResp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("failed to finalize upload: unexpected status %s", putResp.Status)
+	}
+	return nil
+}
+
+func (s *registryStore) PutManifest(repo, ref, mediaType string, data []byte) error {
+	req, err := http.NewRequest(http.MethodPut, (&url.URL{
+		Scheme: "https",
+		Host:   s.registry,
+		Path:   fmt.Sprintf("/v2/%s/manifests/%s", repo, ref),
+	}).String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mediaType)
+	req.ContentLength = int64(len(data))
+	resp, err := s.client.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("unexpected status uploading manifest: %s", resp.Status)
+	}
+	return nil
+}
+
+// ollamaStore implements BlobSource and BlobSink against a directory laid
+// out like ~/.ollama/models (and like the output of run()'s Nix
+// derivation): blobs/sha256-<hex>, manifests/local/<repo>/<tag>.
+type ollamaStore struct {
+	root string
+}
+
+func (s *ollamaStore) blobPath(digest string) string {
+	return filepath.Join(s.root, "blobs", strings.Replace(digest, ":", "-", 1))
+}
+
+func (s *ollamaStore) GetBlob(repo, digest string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(s.blobPath(digest))
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+func (s *ollamaStore) HasBlob(repo, digest string) (bool, error) {
+	_, err := os.Stat(s.blobPath(digest))
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// PutBlob writes r to a temp file in blobs/ and only renames it to the
+// digest-named path once the bytes are confirmed to hash to digest, so a
+// digest mismatch or interrupted copy can never leave the wrong content
+// sitting under a trusted filename.
+func (s *ollamaStore) PutBlob(repo, digest string, size int64, r io.Reader) error {
+	blobsDir := filepath.Join(s.root, "blobs")
+	if err := os.MkdirAll(blobsDir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(blobsDir, ".blob-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(tmp, io.TeeReader(r, hasher)); err != nil {
+		return err
+	}
+	if computed := fmt.Sprintf("sha256:%x", hasher.Sum(nil)); computed != digest {
+		return fmt.Errorf("digest mismatch: expected %s, got %s", digest, computed)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.blobPath(digest))
+}
+
+func (s *ollamaStore) PutManifest(repo, ref, mediaType string, data []byte) error {
+	// There's no registry host in this flow, so manifests are filed under a
+	// synthetic "local" registry directory, matching the shape Ollama
+	// itself expects on disk.
+	dir := filepath.Join(s.root, "manifests", "local", repo)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, ref), data, 0o644)
+}
+
+// ociLayoutStore implements BlobSource and BlobSink against a local OCI
+// image layout directory: oci-layout, index.json, blobs/sha256/<hex>. See
+// https://github.com/opencontainers/image-spec/blob/main/image-layout.md.
+type ociLayoutStore struct {
+	root string
+}
+
+func (s *ociLayoutStore) blobPath(digest string) (string, error) {
+	algo, hex, ok := strings.Cut(digest, ":")
+	if !ok {
+		return "", fmt.Errorf("malformed digest %q", digest)
+	}
+	return filepath.Join(s.root, "blobs", algo, hex), nil
+}
+
+func (s *ociLayoutStore) GetBlob(repo, digest string) (io.ReadCloser, int64, error) {
+	path, err := s.blobPath(digest)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+func (s *ociLayoutStore) HasBlob(repo, digest string) (bool, error) {
+	path, err := s.blobPath(digest)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// PutBlob writes r to a temp file inside the blob's algo directory and
+// only renames it to the digest-named path once the bytes are confirmed to
+// hash to digest, so a digest mismatch or interrupted copy can never leave
+// the wrong content sitting under a trusted filename.
+func (s *ociLayoutStore) PutBlob(repo, digest string, size int64, r io.Reader) error {
+	path, err := s.blobPath(digest)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".blob-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(tmp, io.TeeReader(r, hasher)); err != nil {
+		return err
+	}
+	if computed := fmt.Sprintf("sha256:%x", hasher.Sum(nil)); computed != digest {
+		return fmt.Errorf("digest mismatch: expected %s, got %s", digest, computed)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ociLayoutFile is the marker file required at the root of an OCI layout.
+const ociLayoutFile = `{"imageLayoutVersion":"1.0.0"}`
+
+// ociIndex is a minimal OCI index.json listing a single manifest. PutManifest
+// records no ref name for that entry, so the destination tag is not stored
+// in the layout.
+type ociIndex struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+func (s *ociLayoutStore) PutManifest(repo, ref, mediaType string, data []byte) error {
+	if err := os.MkdirAll(s.root, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(s.root, "oci-layout"), []byte(ociLayoutFile), 0o644); err != nil {
+		return err
+	}
+
+	path, err := s.blobPath(manifestDigest(data))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+
+	index := ociIndex{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIIndex,
+		Manifests: []Descriptor{{
+			Digest:    manifestDigest(data),
+			MediaType: mediaType,
+			Size:      int64(len(data)),
+		}},
+	}
+	indexData, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.root, "index.json"), indexData, 0o644)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withTestClient points http.DefaultClient (what authClient.do calls
+// through) at an httptest.Server's TLS-trusting client for the duration of
+// the test, restoring it afterward. registryStore always dials https, so
+// exercising it against httptest requires a TLS server plus a client that
+// trusts its certificate.
+func withTestClient(t *testing.T, srv *httptest.Server) {
+	t.Helper()
+	orig := http.DefaultClient
+	http.DefaultClient = srv.Client()
+	t.Cleanup(func() { http.DefaultClient = orig })
+}
+
+// chunkedUploadServer returns a distribution-spec chunked upload handler for
+// repo that records the uploaded bytes into uploaded, replying with a
+// Location built by locationFor (relative or absolute, to exercise both).
+func chunkedUploadServer(t *testing.T, repo, digest string, uploaded *string, locationFor func(r *http.Request) string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/"+repo+"/blobs/uploads/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Location", locationFor(r))
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("/v2/"+repo+"/blobs/uploads/session-1", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPatch:
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Errorf("failed to read PATCH body: %v", err)
+			}
+			*uploaded = string(body)
+			w.Header().Set("Location", locationFor(r))
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodPut:
+			if got := r.URL.Query().Get("digest"); got != digest {
+				t.Errorf("finalize PUT digest = %q, want %q", got, digest)
+			}
+			w.WriteHeader(http.StatusCreated)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	return mux
+}
+
+func TestRegistryStorePutBlobRelativeLocation(t *testing.T) {
+	const (
+		repo    = "library/mymodel"
+		digest  = "sha256:deadbeef"
+		content = "blob content"
+	)
+	var uploaded string
+
+	// A relative Location, as the distribution spec permits and as
+	// docker/distribution returns with relative URLs enabled.
+	srv := httptest.NewTLSServer(chunkedUploadServer(t, repo, digest, &uploaded, func(r *http.Request) string {
+		return "/v2/" + repo + "/blobs/uploads/session-1"
+	}))
+	defer srv.Close()
+	withTestClient(t, srv)
+
+	store := &registryStore{
+		registry: strings.TrimPrefix(srv.URL, "https://"),
+		client:   newAuthClient("", "", "", ""),
+	}
+
+	if err := store.PutBlob(repo, digest, int64(len(content)), strings.NewReader(content)); err != nil {
+		t.Fatalf("PutBlob() error = %v", err)
+	}
+	if uploaded != content {
+		t.Errorf("uploaded body = %q, want %q", uploaded, content)
+	}
+}
+
+func TestRegistryStorePutBlobAbsoluteLocation(t *testing.T) {
+	const (
+		repo    = "library/mymodel"
+		digest  = "sha256:deadbeef"
+		content = "blob content"
+	)
+	var uploaded string
+
+	srv := httptest.NewTLSServer(chunkedUploadServer(t, repo, digest, &uploaded, func(r *http.Request) string {
+		return fmt.Sprintf("https://%s/v2/%s/blobs/uploads/session-1", r.Host, repo)
+	}))
+	defer srv.Close()
+	withTestClient(t, srv)
+
+	store := &registryStore{
+		registry: strings.TrimPrefix(srv.URL, "https://"),
+		client:   newAuthClient("", "", "", ""),
+	}
+
+	if err := store.PutBlob(repo, digest, int64(len(content)), strings.NewReader(content)); err != nil {
+		t.Fatalf("PutBlob() error = %v", err)
+	}
+	if uploaded != content {
+		t.Errorf("uploaded body = %q, want %q", uploaded, content)
+	}
+}
+
+func TestRegistryStoreHasBlob(t *testing.T) {
+	const repo = "library/mymodel"
+
+	tests := []struct {
+		name   string
+		status int
+		want   bool
+	}{
+		{name: "blob present", status: http.StatusOK, want: true},
+		{name: "blob absent", status: http.StatusNotFound, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodHead {
+					t.Errorf("method = %s, want HEAD", r.Method)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+			withTestClient(t, srv)
+
+			store := &registryStore{
+				registry: strings.TrimPrefix(srv.URL, "https://"),
+				client:   newAuthClient("", "", "", ""),
+			}
+
+			got, err := store.HasBlob(repo, "sha256:deadbeef")
+			if err != nil {
+				t.Fatalf("HasBlob() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("HasBlob() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
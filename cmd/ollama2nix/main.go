@@ -1,15 +1,11 @@
 package main
 
 import (
-	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"log/slog"
-	"net/http"
 	"net/url"
 	"os"
 	"strings"
@@ -67,30 +63,43 @@ import (
 //	   }
 //	 ]
 //	}
-type Manifest struct {
-	SchemaVersion int     `json:"schemaVersion"`
-	MediaType     string  `json:"mediaType"`
-	Config        Layer   `json:"config"`
-	Layers        []Layer `json:"layers"`
-}
-
-type Layer struct {
-	Digest    string `json:"digest"`
-	MediaType string `json:"mediaType"`
-	Size      int64  `json:"size"`
-}
+// See ImageManifest and Index in manifest.go for the parsed shape of the
+// above, including the manifest-list/index case.
 
 var flagRegistry = flag.String("registry", "registry.ollama.ai", "Registry to download models from.")
 var flagModel = flag.String("model", "", "Name of the model to download, e.g. mistral-nemo, or mistral-nemo:7b")
+var flagVariant = flag.String("variant", "", "Quantization variant to select when the registry returns a manifest list, e.g. q4_0. Leave empty to take the first entry.")
+var flagPlatform = flag.String("platform", "", "Platform to select when the registry returns a manifest list, e.g. linux/amd64. Leave empty to take the first entry.")
+var flagUsername = flag.String("username", "", "Username for registry authentication. Falls back to Docker config credentials for the registry if unset.")
+var flagPassword = flag.String("password", "", "Password for registry authentication.")
+var flagToken = flag.String("token", "", "Bearer token for registry authentication, bypassing the token endpoint entirely.")
 
 func main() {
-	flag.Parse()
-	if err := run(); err != nil {
+	if err := dispatch(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 }
 
+// dispatch runs the "serve", "copy" or "build" subcommand when invoked as
+// `ollama2nix serve ...` (and likewise for the others), and otherwise falls back to the original default behaviour of
+// generating a Nix expression from -registry/-model, so existing invocations
+// keep working unchanged.
+func dispatch() error {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			return runServe(os.Args[2:])
+		case "copy":
+			return runCopy(os.Args[2:])
+		case "build":
+			return runBuild(os.Args[2:])
+		}
+	}
+	flag.Parse()
+	return run()
+}
+
 func run() (err error) {
 	log := slog.New(slog.NewJSONHandler(os.Stderr, nil))
 	if *flagRegistry == "" {
@@ -105,23 +114,23 @@ func run() (err error) {
 	if len(modelVersion) > 1 {
 		version = modelVersion[1]
 	}
-	manifestURL := url.URL{
-		Scheme: "https",
-		Host:   *flagRegistry,
-		Path:   fmt.Sprintf("/v2/library/%s/manifests/%s", url.PathEscape(model), url.PathEscape(version)),
-	}
-	log.Debug("Downloading manifest", slog.String("url", manifestURL.String()))
+	repo := repoForModel(model)
+	client := newAuthClient(*flagRegistry, *flagUsername, *flagPassword, *flagToken)
 
-	resp, err := http.Get(manifestURL.String())
+	selector := ManifestSelector{Variant: *flagVariant, Platform: *flagPlatform}
+	log.Debug("Resolving manifest", slog.String("registry", *flagRegistry), slog.String("repo", repo), slog.String("ref", version))
+	_, manifestDigestStr, manifest, err := fetchManifest(client, *flagRegistry, repo, version, selector)
 	if err != nil {
-		return fmt.Errorf("failed to download manifest: %w", err)
+		return fmt.Errorf("failed to resolve manifest: %w", err)
 	}
-	defer resp.Body.Close()
 
-	manifestHash := sha256.New()
-	var manifest Manifest
-	if err := json.NewDecoder(io.TeeReader(resp.Body, manifestHash)).Decode(&manifest); err != nil {
-		return fmt.Errorf("failed to decode manifest: %w", err)
+	// Fetch the resolved child manifest by digest, so the Nix fetchurl hash
+	// always matches what's actually downloaded, even when the tag points at
+	// a manifest list rather than this concrete image manifest.
+	manifestURL := url.URL{
+		Scheme: "https",
+		Host:   *flagRegistry,
+		Path:   fmt.Sprintf("/v2/%s/manifests/%s", repo, url.PathEscape(manifestDigestStr)),
 	}
 
 	var sb strings.Builder
@@ -133,11 +142,19 @@ func run() (err error) {
 		blobURL := url.URL{
 			Scheme: "https",
 			Host:   *flagRegistry,
-			Path:   fmt.Sprintf("/v2/library/mistral-nemo/blobs/%s", url.PathEscape(layer.Digest)),
+			Path:   fmt.Sprintf("/v2/%s/blobs/%s", repo, url.PathEscape(layer.Digest)),
+		}
+		finalURL, token, err := client.resolveBlobLocation(blobURL.String())
+		if err != nil {
+			return fmt.Errorf("failed to resolve blob location for %s: %w", layer.Digest, err)
+		}
+		curlOpts := `"-L" "-H" "Accept:application/octet-stream"`
+		if token != "" {
+			curlOpts += fmt.Sprintf(" \"-H\" \"Authorization: Bearer %s\"", token)
 		}
 		sb.WriteString(fmt.Sprintf("  blob_%d = pkgs.fetchurl {\n", i))
-		sb.WriteString(fmt.Sprintf("    curlOptsList = [\"-L\" \"-H\" \"Accept:application/octet-stream\"];\n"))
-		sb.WriteString(fmt.Sprintf("    url = %q;\n", blobURL.String()))
+		sb.WriteString(fmt.Sprintf("    curlOptsList = [%s];\n", curlOpts))
+		sb.WriteString(fmt.Sprintf("    url = %q;\n", finalURL))
 		blobNixHash, err := convertOllamaHashToNixHash(layer.Digest)
 		if err != nil {
 			return fmt.Errorf("failed to convert blob hash: %w", err)
@@ -150,8 +167,11 @@ func run() (err error) {
 	sb.WriteString("  manifestFile = pkgs.fetchurl {\n")
 	sb.WriteString(fmt.Sprintf("    curlOptsList = [\"-L\" \"-H\" \"Accept:application/octet-stream\"];\n"))
 	sb.WriteString(fmt.Sprintf("    url = %q;\n", manifestURL.String()))
-	base64Hash := base64.StdEncoding.EncodeToString(manifestHash.Sum(nil))
-	sb.WriteString(fmt.Sprintf("    hash = %q;\n", "sha256-"+base64Hash))
+	manifestNixHash, err := convertOllamaHashToNixHash(manifestDigestStr)
+	if err != nil {
+		return fmt.Errorf("failed to convert manifest hash: %w", err)
+	}
+	sb.WriteString(fmt.Sprintf("    hash = %q;\n", manifestNixHash))
 	sb.WriteString("  };\n")
 	sb.WriteString("in\n")
 	sb.WriteString("  # Use symlinkJoin to create the final symlinked structure.\n")
@@ -175,14 +195,25 @@ func run() (err error) {
 	}
 	sb.WriteString("\n")
 	sb.WriteString("      # Move manifest file to the appropriate directory.\n")
-	sb.WriteString(fmt.Sprintf("      mkdir -p $out/manifests/%s/%s\n", *flagRegistry, model))
-	sb.WriteString(fmt.Sprintf("      ln -s ${manifestFile} $out/manifests/%s/%s/%s\n", *flagRegistry, model, version))
+	sb.WriteString(fmt.Sprintf("      mkdir -p $out/manifests/%s/%s\n", *flagRegistry, repo))
+	sb.WriteString(fmt.Sprintf("      ln -s ${manifestFile} $out/manifests/%s/%s/%s\n", *flagRegistry, repo, version))
 	sb.WriteString("    '';\n")
 	sb.WriteString("  }\n")
 	fmt.Println(sb.String())
 	return nil
 }
 
+// repoForModel turns a -model value into a registry repository path.
+// Bare names like "mistral-nemo" live under the implicit "library"
+// namespace, matching Ollama's registry; namespaced names like
+// "user/model" are passed through unchanged.
+func repoForModel(model string) string {
+	if strings.Contains(model, "/") {
+		return model
+	}
+	return fmt.Sprintf("library/%s", model)
+}
+
 func convertOllamaHashToNixHash(hexHash string) (nixHash string, err error) {
 	// Remove the "sha256:" prefix
 	hexHash = strings.TrimPrefix(hexHash, "sha256:")
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Media types that the Ollama registry (and any standards-compliant OCI
+// registry) may return for a manifest request. A tag can resolve directly to
+// an image manifest, or to a manifest list / image index that fans out to
+// one image manifest per platform/variant.
+const (
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+// manifestAccept is the Accept header sent with every manifest request, so
+// the registry is free to respond with either a concrete image manifest or a
+// manifest list/index.
+var manifestAccept = strings.Join([]string{
+	mediaTypeDockerManifest,
+	mediaTypeDockerManifestList,
+	mediaTypeOCIManifest,
+	mediaTypeOCIIndex,
+}, ", ")
+
+// Layer is a content-addressed blob referenced by an ImageManifest, e.g. the
+// model weights, template, license or params file.
+type Layer struct {
+	Digest    string `json:"digest"`
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+}
+
+// ImageManifest is a concrete, single-variant manifest: the schema-2 shape
+// documented at the top of main.go.
+type ImageManifest struct {
+	SchemaVersion int     `json:"schemaVersion"`
+	MediaType     string  `json:"mediaType"`
+	Config        Layer   `json:"config"`
+	Layers        []Layer `json:"layers"`
+}
+
+// Platform describes the target of an Index entry. Ollama repurposes this to
+// also carry the quantization variant, since there's no standard field for
+// it.
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// Descriptor is one entry in an Index, pointing at a child manifest.
+type Descriptor struct {
+	Digest    string    `json:"digest"`
+	MediaType string    `json:"mediaType"`
+	Size      int64     `json:"size"`
+	Platform  *Platform `json:"platform,omitempty"`
+}
+
+// Index is a manifest list / image index: a fan-out to one ImageManifest per
+// platform or quantization variant.
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// manifestEnvelope is decoded first to tell an Index apart from an
+// ImageManifest: both share schemaVersion/mediaType, but only one of
+// "manifests" or "layers" is populated.
+type manifestEnvelope struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	MediaType     string `json:"mediaType"`
+}
+
+// ManifestSelector picks one child manifest out of an Index.
+type ManifestSelector struct {
+	// Variant matches Platform.Variant, e.g. "q4_0".
+	Variant string
+	// Platform matches "<os>/<architecture>", e.g. "linux/amd64".
+	Platform string
+}
+
+// Select returns the descriptor from index that matches the selector. When
+// neither Variant nor Platform is set, it falls back to the first entry.
+func (s ManifestSelector) Select(index Index) (Descriptor, error) {
+	if len(index.Manifests) == 0 {
+		return Descriptor{}, fmt.Errorf("manifest list has no entries")
+	}
+	if s.Variant == "" && s.Platform == "" {
+		return index.Manifests[0], nil
+	}
+	for _, d := range index.Manifests {
+		if s.Variant != "" {
+			if d.Platform == nil || d.Platform.Variant != s.Variant {
+				continue
+			}
+		}
+		if s.Platform != "" {
+			if d.Platform == nil || fmt.Sprintf("%s/%s", d.Platform.OS, d.Platform.Architecture) != s.Platform {
+				continue
+			}
+		}
+		return d, nil
+	}
+	return Descriptor{}, fmt.Errorf("no manifest matching variant %q platform %q", s.Variant, s.Platform)
+}
+
+// resolveManifest fetches the manifest for repo:ref from registry and
+// resolves it down to a concrete ImageManifest, following a manifest
+// list/index via selector if one is returned. It returns the raw bytes of
+// the resolved ImageManifest (so the caller can hash exactly what was
+// selected, not the top-level index) alongside the decoded struct.
+func resolveManifest(client *authClient, registry, repo, ref string, selector ManifestSelector) (data []byte, manifest ImageManifest, err error) {
+	body, contentType, err := getManifest(client, registry, repo, ref)
+	if err != nil {
+		return nil, ImageManifest{}, err
+	}
+
+	var envelope manifestEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, ImageManifest{}, fmt.Errorf("failed to decode manifest envelope: %w", err)
+	}
+
+	switch mediaType(contentType, envelope.MediaType) {
+	case mediaTypeDockerManifestList, mediaTypeOCIIndex:
+		var index Index
+		if err := json.Unmarshal(body, &index); err != nil {
+			return nil, ImageManifest{}, fmt.Errorf("failed to decode manifest list: %w", err)
+		}
+		descriptor, err := selector.Select(index)
+		if err != nil {
+			return nil, ImageManifest{}, fmt.Errorf("failed to select manifest from list: %w", err)
+		}
+		return resolveManifest(client, registry, repo, descriptor.Digest, selector)
+	default:
+		var imageManifest ImageManifest
+		if err := json.Unmarshal(body, &imageManifest); err != nil {
+			return nil, ImageManifest{}, fmt.Errorf("failed to decode image manifest: %w", err)
+		}
+		return body, imageManifest, nil
+	}
+}
+
+// fetchManifest resolves repo:ref to a concrete ImageManifest, same as
+// resolveManifest, additionally returning its digest so callers don't each
+// have to call manifestDigest themselves.
+func fetchManifest(client *authClient, registry, repo, ref string, selector ManifestSelector) (data []byte, digest string, manifest ImageManifest, err error) {
+	data, manifest, err = resolveManifest(client, registry, repo, ref, selector)
+	if err != nil {
+		return nil, "", ImageManifest{}, err
+	}
+	return data, manifestDigest(data), manifest, nil
+}
+
+// mediaType prefers the Content-Type header, falling back to the mediaType
+// field recorded inside the manifest body itself: some registries serve
+// manifests with a generic "application/json" Content-Type.
+func mediaType(contentType, bodyMediaType string) string {
+	ct, _, _ := strings.Cut(contentType, ";")
+	ct = strings.TrimSpace(ct)
+	if ct != "" && ct != "application/json" {
+		return ct
+	}
+	return bodyMediaType
+}
+
+// getManifest issues a manifest GET, authenticating via client if the
+// registry challenges the request, and returns the raw body alongside the
+// response Content-Type.
+func getManifest(client *authClient, registry, repo, ref string) (body []byte, contentType string, err error) {
+	manifestURL := url.URL{
+		Scheme: "https",
+		Host:   registry,
+		Path:   fmt.Sprintf("/v2/%s/manifests/%s", repo, url.PathEscape(ref)),
+	}
+
+	req, err := http.NewRequest(http.MethodGet, manifestURL.String(), nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to build manifest request: %w", err)
+	}
+	req.Header.Set("Accept", manifestAccept)
+
+	resp, err := client.do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to download manifest: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to download manifest: unexpected status %s", resp.Status)
+	}
+
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read manifest: %w", err)
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+// manifestDigest returns the sha256 digest of data in Ollama's
+// "sha256:<hex>" form, matching the scheme used for layer digests.
+func manifestDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("sha256:%x", sum)
+}
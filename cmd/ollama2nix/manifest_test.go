@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestManifestSelectorSelect(t *testing.T) {
+	index := Index{
+		Manifests: []Descriptor{
+			{Digest: "sha256:q4", Platform: &Platform{OS: "linux", Architecture: "amd64", Variant: "q4_0"}},
+			{Digest: "sha256:q8", Platform: &Platform{OS: "linux", Architecture: "amd64", Variant: "q8_0"}},
+			{Digest: "sha256:arm", Platform: &Platform{OS: "linux", Architecture: "arm64", Variant: "q4_0"}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		selector ManifestSelector
+		index    Index
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "no selector falls back to first entry",
+			selector: ManifestSelector{},
+			index:    index,
+			want:     "sha256:q4",
+		},
+		{
+			name:     "selects by variant alone",
+			selector: ManifestSelector{Variant: "q8_0"},
+			index:    index,
+			want:     "sha256:q8",
+		},
+		{
+			name:     "selects by platform alone",
+			selector: ManifestSelector{Platform: "linux/arm64"},
+			index:    index,
+			want:     "sha256:arm",
+		},
+		{
+			name:     "selects by variant and platform together",
+			selector: ManifestSelector{Variant: "q4_0", Platform: "linux/arm64"},
+			index:    index,
+			want:     "sha256:arm",
+		},
+		{
+			name:     "no match returns an error",
+			selector: ManifestSelector{Variant: "q4_0", Platform: "linux/amd64"},
+			index: Index{Manifests: []Descriptor{
+				{Digest: "sha256:q8", Platform: &Platform{OS: "linux", Architecture: "amd64", Variant: "q8_0"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name:     "empty index is an error",
+			selector: ManifestSelector{},
+			index:    Index{},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.selector.Select(tt.index)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Select() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Digest != tt.want {
+				t.Errorf("Select() = %q, want %q", got.Digest, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runServe implements the "serve" subcommand: an HTTP server speaking the
+// subset of the OCI distribution v2 API that `ollama pull` needs, backed by
+// a directory laid out exactly as the Nix derivation from run() produces
+// (manifests/<registry>/<repo>/<tag> and blobs/sha256-<hex>). This lets a
+// `nix build` output be pulled by a real Ollama client without a registry in
+// between, e.g. for air-gapped installs.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	root := fs.String("root", filepath.Join(os.Getenv("HOME"), ".ollama", "models"), "Directory laid out like a Nix model derivation output, or ~/.ollama/models, to serve.")
+	listen := fs.String("listen", "127.0.0.1:11434", "Address to listen on.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	log := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	srv := &registryServer{root: *root, log: log}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/", srv.handle)
+
+	log.Info("Serving model store", slog.String("root", *root), slog.String("listen", *listen))
+	return http.ListenAndServe(*listen, mux)
+}
+
+// registryServer serves the subset of the OCI distribution v2 API that
+// `ollama pull` uses, reading directly from root.
+type registryServer struct {
+	root string
+	log  *slog.Logger
+}
+
+func (s *registryServer) handle(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	if path == "" {
+		w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if i := strings.LastIndex(path, "/manifests/"); i >= 0 {
+		s.handleManifest(w, r, path[:i], path[i+len("/manifests/"):])
+		return
+	}
+	if i := strings.LastIndex(path, "/blobs/"); i >= 0 {
+		s.handleBlob(w, r, path[:i], path[i+len("/blobs/"):])
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// handleManifest serves GET/HEAD /v2/<repo>/manifests/<ref>. The registry
+// segment of the on-disk layout isn't part of the pull path, so the store is
+// expected to contain manifests for exactly one registry; the first match is
+// served.
+func (s *registryServer) handleManifest(w http.ResponseWriter, r *http.Request, repo, ref string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	matches, err := filepath.Glob(filepath.Join(s.root, "manifests", "*", repo, ref))
+	if err != nil || len(matches) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := os.ReadFile(matches[0])
+	if err != nil {
+		s.log.Error("failed to read manifest", slog.String("path", matches[0]), slog.Any("error", err))
+		http.Error(w, "failed to read manifest", http.StatusInternalServerError)
+		return
+	}
+
+	var envelope manifestEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		s.log.Error("failed to decode manifest", slog.String("path", matches[0]), slog.Any("error", err))
+		http.Error(w, "failed to decode manifest", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", envelope.MediaType)
+	w.Header().Set("Docker-Content-Digest", manifestDigest(data))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(data)
+}
+
+// handleBlob serves GET/HEAD /v2/<repo>/blobs/<digest>, supporting range
+// requests so resumed pulls work.
+func (s *registryServer) handleBlob(w http.ResponseWriter, r *http.Request, repo, digest string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	blobPath := filepath.Join(s.root, "blobs", strings.Replace(digest, ":", "-", 1))
+	f, err := os.Open(blobPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		s.log.Error("failed to stat blob", slog.String("path", blobPath), slog.Any("error", err))
+		http.Error(w, "failed to stat blob", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", digest)
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
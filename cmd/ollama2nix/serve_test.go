@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestRegistryServer(t *testing.T) *registryServer {
+	t.Helper()
+	return &registryServer{
+		root: t.TempDir(),
+		log:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+func TestRegistryServerHandleManifest(t *testing.T) {
+	srv := newTestRegistryServer(t)
+	const (
+		repo = "library/mymodel"
+		ref  = "latest"
+		data = `{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`
+	)
+	manifestDir := filepath.Join(srv.root, "manifests", "registry.ollama.ai", repo)
+	if err := os.MkdirAll(manifestDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(manifestDir, ref), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("GET returns the manifest body and digest header", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v2/"+repo+"/manifests/"+ref, nil)
+		srv.handle(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if got := w.Body.String(); got != data {
+			t.Errorf("body = %q, want %q", got, data)
+		}
+		if got, want := w.Header().Get("Docker-Content-Digest"), manifestDigest([]byte(data)); got != want {
+			t.Errorf("Docker-Content-Digest = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("HEAD returns headers with no body", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodHead, "/v2/"+repo+"/manifests/"+ref, nil)
+		srv.handle(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("HEAD body = %q, want empty", w.Body.String())
+		}
+	})
+
+	t.Run("unknown ref is a 404", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v2/"+repo+"/manifests/missing", nil)
+		srv.handle(w, r)
+
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("POST is not allowed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/v2/"+repo+"/manifests/"+ref, nil)
+		srv.handle(w, r)
+
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func TestRegistryServerHandleBlob(t *testing.T) {
+	srv := newTestRegistryServer(t)
+	const (
+		repo    = "library/mymodel"
+		digest  = "sha256:deadbeef"
+		content = "0123456789"
+	)
+	blobsDir := filepath.Join(srv.root, "blobs")
+	if err := os.MkdirAll(blobsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(blobsDir, "sha256-deadbeef"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("GET returns the full blob", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v2/"+repo+"/blobs/"+digest, nil)
+		srv.handle(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if got := w.Body.String(); got != content {
+			t.Errorf("body = %q, want %q", got, content)
+		}
+		if got := w.Header().Get("Docker-Content-Digest"); got != digest {
+			t.Errorf("Docker-Content-Digest = %q, want %q", got, digest)
+		}
+	})
+
+	t.Run("Range request returns a partial blob", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v2/"+repo+"/blobs/"+digest, nil)
+		r.Header.Set("Range", "bytes=2-4")
+		srv.handle(w, r)
+
+		if w.Code != http.StatusPartialContent {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusPartialContent)
+		}
+		if got, want := w.Body.String(), content[2:5]; got != want {
+			t.Errorf("body = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("unknown digest is a 404", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v2/"+repo+"/blobs/sha256:missing", nil)
+		srv.handle(w, r)
+
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("POST is not allowed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/v2/"+repo+"/blobs/"+digest, nil)
+		srv.handle(w, r)
+
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+		}
+	})
+}